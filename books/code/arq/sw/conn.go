@@ -0,0 +1,647 @@
+package sw
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RTO估计的上下限
+const (
+	_MIN_RTO = 30 * time.Millisecond
+	_MAX_RTO = 60 * time.Second
+)
+
+// maxPktSize是单个UDP报文的读取缓冲区大小，需能容纳mss加上各类协议头
+const maxPktSize = 2048
+
+// finTimeout是Close发送FIN后等待对端确认的上限
+const finTimeout = 3 * time.Second
+
+// Conn的连接状态
+const (
+	stateConnecting int32 = iota
+	stateEstablished
+	stateClosing
+	stateClosed
+)
+
+// outSeg记录一个已发送但尚未确认的分片
+type outSeg struct {
+	seg           segment
+	sentAt        time.Time
+	retransmitted bool
+}
+
+// Conn是绑定到单个对端的可靠UDP连接，实现net.Conn。
+// 它由Listener.Accept在服务端产生，或由Dial在客户端产生。
+type Conn struct {
+	laddr net.Addr
+	raddr *net.UDPAddr
+
+	// write把一个已编码好的报文发给对端；Listener一侧的Conn复用共享socket
+	// 按raddr发送，Dial一侧的Conn通过自己独占的已连接socket发送。
+	write func([]byte) (int, error)
+
+	// closeFn在握手/挥手完成后释放底层资源（共享socket只解除demux，独占socket直接关闭）
+	closeFn func() error
+
+	state int32
+
+	synAckCh  chan struct{}
+	finAckCh  chan struct{}
+	closedCh  chan struct{}
+	closeOnce sync.Once
+
+	// 接收缓冲区，存放已重组为完整消息、可交付给上层的数据
+	rcvMu        sync.Mutex
+	rcvbuf       *list.List
+	readDeadline time.Time
+	rdLeftover   []byte
+
+	// 乱序到达、尚未凑齐空洞的分片，以seq为key
+	ooBuf map[uint32]segment
+
+	// 按消息id重组分片的有界LRU缓冲区，由rcvMu保护
+	reassembleLRU     *list.List
+	reassembleIndex   map[uint32]*list.Element
+	reassemblyTimeout time.Duration
+
+	// 收到push事件
+	recvPush chan struct{}
+
+	// 下一个发送序列号
+	nextSeq uint32
+
+	// 下一个待分配的消息id，每次Write分配一个，其所有分片共享该id
+	nextMsgID uint32
+
+	// 下一个期望收到的序列号（累计确认点）
+	// 小于expectSeq的视为重复/延迟包，大于的视为乱序包缓存等待
+	expectSeq uint32
+
+	// mss，超过mss则进行分片
+	mss int
+
+	// 发送窗口：允许同时在途的未确认分片数
+	swnd int
+
+	// 发送队列，按序保存在途分片，键为seq，便于按seq重传/确认
+	sendMu        sync.Mutex
+	sendQ         *list.List
+	sendIndex     map[uint32]*list.Element
+	writeDeadline time.Time
+
+	// 发送窗口有空闲位置时唤醒阻塞的Write
+	writable chan bool
+
+	// rtt采样，微妙级别
+	rtt    int64
+	minrtt int64
+	maxrtt int64
+
+	// RTT估计（Jacobson/Karn算法）与由此推出的RTO，由rtoMu保护
+	rtoMu      sync.Mutex
+	srtt       time.Duration
+	rttvar     time.Duration
+	rto        time.Duration
+	backoffRto time.Duration // 超时重传指数回退的当前值，0表示未处于回退状态
+
+	// 超时计时器，只为队首（最旧）未确认分片计时
+	timer *time.Timer
+}
+
+// newConn构造一个处于连接建立流程中的Conn，laddr/raddr为本地/对端地址，
+// write负责把编码好的报文发出去，closeFn在连接释放时被调用。
+func newConn(laddr net.Addr, raddr *net.UDPAddr, write func([]byte) (int, error), closeFn func() error) *Conn {
+	c := &Conn{
+		laddr:           laddr,
+		raddr:           raddr,
+		write:           write,
+		closeFn:         closeFn,
+		state:           stateConnecting,
+		synAckCh:        make(chan struct{}, 1),
+		finAckCh:        make(chan struct{}, 1),
+		closedCh:        make(chan struct{}),
+		rcvbuf:          list.New(),
+		ooBuf:           make(map[uint32]segment),
+		reassembleLRU:   list.New(),
+		reassembleIndex: make(map[uint32]*list.Element),
+		// 带1个缓冲：投递发生在reader检查完rcvbuf、还没进入select之前时，
+		// 非阻塞发送不会被无人接收而丢弃，避免丢失唤醒导致Read永久阻塞。
+		recvPush:  make(chan struct{}, 1),
+		sendQ:     list.New(),
+		sendIndex: make(map[uint32]*list.Element),
+		writable:  make(chan bool, 1),
+		timer:     time.NewTimer(defaultRto),
+		mss:       1400,
+		swnd:      defaultSwnd,
+		minrtt:    math.MaxInt64,
+		maxrtt:    math.MinInt64,
+		rto:       defaultRto,
+	}
+	c.timer.Stop()
+	go c.internalSendLoop()
+	return c
+}
+
+// SetSendWindow设置发送窗口大小，即允许同时在途的未确认分片数。
+// SAck位图只能描述base之后sackBits个分片的到达状态，因此窗口上限被
+// 封顶在sackBits：超过这个值的乱序分片永远没法被SACK标记到，丢包后
+// 即便已经到达也会被误判为空洞而重传。
+func (c *Conn) SetSendWindow(n int) {
+	if n > sackBits {
+		n = sackBits
+	}
+	c.sendMu.Lock()
+	c.swnd = n
+	c.sendMu.Unlock()
+}
+
+func (c *Conn) LocalAddr() net.Addr  { return c.laddr }
+func (c *Conn) RemoteAddr() net.Addr { return c.raddr }
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.rcvMu.Lock()
+	c.readDeadline = t
+	c.rcvMu.Unlock()
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.sendMu.Lock()
+	c.writeDeadline = t
+	c.sendMu.Unlock()
+	return nil
+}
+
+// Read从连接中读取一条完整消息，必要时跨多次Read返回同一条消息的剩余部分
+func (c *Conn) Read(b []byte) (int, error) {
+	if len(c.rdLeftover) == 0 {
+		data, err := c.peek()
+		if err != nil {
+			return 0, err
+		}
+		c.rdLeftover = data
+	}
+	n := copy(b, c.rdLeftover)
+	c.rdLeftover = c.rdLeftover[n:]
+	return n, nil
+}
+
+// Peek返回下一条完整的应用消息。与Read不同，它不会把一条消息拆分到多次调用中返回，
+// 调用方总是拿到Write时传入的那一整块数据（已经过分片重组与CRC32校验）。
+func (c *Conn) Peek() ([]byte, error) {
+	return c.peek()
+}
+
+// Recv是Peek的别名，读作"接收一条完整消息"
+func (c *Conn) Recv() ([]byte, error) {
+	return c.peek()
+}
+
+func (c *Conn) peek() ([]byte, error) {
+	c.rcvMu.Lock()
+	if c.rcvbuf.Len() > 0 {
+		ele := c.rcvbuf.Front()
+		seg := ele.Value.(segment)
+		c.rcvbuf.Remove(ele)
+		c.rcvMu.Unlock()
+		return seg.data, nil
+	}
+	deadline := c.readDeadline
+	c.rcvMu.Unlock()
+
+	var timeoutCh <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return nil, errTimeout
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeoutCh = t.C
+	}
+
+	for {
+		select {
+		case <-c.recvPush:
+			c.rcvMu.Lock()
+			if c.rcvbuf.Len() > 0 {
+				ele := c.rcvbuf.Front()
+				seg := ele.Value.(segment)
+				c.rcvbuf.Remove(ele)
+				c.rcvMu.Unlock()
+				return seg.data, nil
+			}
+			c.rcvMu.Unlock()
+			// 虚假唤醒：recvPush只应在有消息投递时发出，但为稳妥起见仍重新
+			// 检查一次rcvbuf；没有数据就继续等下一次真正的投递，而不是报错。
+		case <-timeoutCh:
+			return nil, errTimeout
+		case <-c.closedCh:
+			return nil, io.EOF
+		}
+	}
+}
+
+// Write把b作为一条消息发送给对端，超过mss时自动分片；所有分片共享同一个消息id，
+// 接收端据此重组为一条完整消息后才会交付给上层。
+func (c *Conn) Write(b []byte) (int, error) {
+	if atomic.LoadInt32(&c.state) != stateEstablished {
+		return 0, fmt.Errorf("sw: use of closed connection")
+	}
+
+	msgID := atomic.AddUint32(&c.nextMsgID, 1)
+
+	if len(b) > c.mss {
+		log.Println("[D] fragment")
+		fragCnt := uint16((len(b) + c.mss - 1) / c.mss)
+		var fragIdx uint16
+		for p := 0; p < len(b); {
+			pos := p + c.mss
+			if pos > len(b) {
+				pos = len(b)
+			}
+			if err := c.enqueue(b[p:pos], msgID, fragIdx, fragCnt); err != nil {
+				return p, err
+			}
+			p = pos
+			fragIdx++
+		}
+	} else {
+		if err := c.enqueue(b, msgID, 0, 1); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// enqueue把一个分片放入发送窗口；窗口满时阻塞，直到收到ack腾出空位或超过写超时
+func (c *Conn) enqueue(data []byte, msgID uint32, fragIdx, fragCnt uint16) error {
+	for {
+		c.sendMu.Lock()
+		if c.sendQ.Len() < c.swnd {
+			break
+		}
+		deadline := c.writeDeadline
+		c.sendMu.Unlock()
+
+		var timeoutCh <-chan time.Time
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return errTimeout
+			}
+			t := time.NewTimer(d)
+			defer t.Stop()
+			timeoutCh = t.C
+		}
+
+		select {
+		case <-c.writable:
+		case <-timeoutCh:
+			return errTimeout
+		case <-c.closedCh:
+			return fmt.Errorf("sw: use of closed connection")
+		}
+	}
+
+	seg := segment{cmd: cmdPush, seq: c.nextSeq, data: data, msgID: msgID, fragIdx: fragIdx, fragCnt: fragCnt}
+	c.nextSeq++
+
+	os := &outSeg{seg: seg, sentAt: time.Now()}
+	ele := c.sendQ.PushBack(os)
+	c.sendIndex[seg.seq] = ele
+	first := c.sendQ.Front() == ele
+	c.sendMu.Unlock()
+
+	c.tx(seg)
+	if first {
+		c.timer.Reset(c.currentTimeout())
+	}
+	return nil
+}
+
+// internalSendLoop只负责队首分片的超时重传；确认与窗口推进由input触发。
+// release/Close会close(closedCh)但从不drain或close timer.C，所以必须
+// 显式select上closedCh才能在连接释放后退出，否则每个连接都会泄漏这个协程。
+func (c *Conn) internalSendLoop() {
+	for {
+		select {
+		case <-c.timer.C:
+		case <-c.closedCh:
+			return
+		}
+
+		c.sendMu.Lock()
+		ele := c.sendQ.Front()
+		if ele == nil {
+			c.sendMu.Unlock()
+			continue
+		}
+		os := ele.Value.(*outSeg)
+		os.retransmitted = true
+		c.sendMu.Unlock()
+
+		log.Println("resend ", os.seg.seq)
+		c.tx(os.seg)
+		// Karn算法：重传过的分片不参与RTT采样，超时时间指数回退，直到收到新鲜ack
+		c.timer.Reset(c.backoff())
+	}
+}
+
+// input处理一个已解析好、确认属于本连接的报文
+func (c *Conn) input(seg segment) {
+	switch seg.cmd {
+	case cmdSYN:
+		// SYNACK丢失导致对端重发SYN，原样重传SYNACK
+		c.tx(segment{cmd: cmdSYNACK})
+	case cmdSYNACK:
+		if atomic.CompareAndSwapInt32(&c.state, stateConnecting, stateEstablished) {
+			select {
+			case c.synAckCh <- struct{}{}:
+			default:
+			}
+		}
+	case cmdFIN:
+		c.tx(segment{cmd: cmdAck})
+		c.handlePeerClose()
+	case cmdAck:
+		// 挥手(FIN)确认，与数据确认(cmdSAck)区分开
+		select {
+		case c.finAckCh <- struct{}{}:
+		default:
+		}
+	case cmdRST:
+		c.handlePeerClose()
+	case cmdSAck:
+		c.handleSAck(seg.seq, seg.sackBitmap)
+	default:
+		c.handlePush(seg)
+	}
+}
+
+// handlePeerClose处理对端主动挥手或复位：确认后的连接状态转为closed，唤醒阻塞的Read
+func (c *Conn) handlePeerClose() {
+	if atomic.CompareAndSwapInt32(&c.state, stateEstablished, stateClosed) ||
+		atomic.CompareAndSwapInt32(&c.state, stateConnecting, stateClosed) {
+		c.release()
+	}
+}
+
+// release只做一次性的资源回收，可由主动Close或被动挥手触发
+func (c *Conn) release() {
+	c.closeOnce.Do(func() {
+		c.timer.Stop()
+		close(c.closedCh)
+		if c.closeFn != nil {
+			c.closeFn()
+		}
+	})
+}
+
+// Close发送FIN并等待对端确认（超过finTimeout也继续关闭），然后释放连接资源
+func (c *Conn) Close() error {
+	old := atomic.SwapInt32(&c.state, stateClosing)
+	if old == stateClosed {
+		return nil
+	}
+	if old == stateClosing {
+		return nil
+	}
+
+	c.tx(segment{cmd: cmdFIN})
+	select {
+	case <-c.finAckCh:
+	case <-time.After(finTimeout):
+		log.Println("[W] fin ack timeout, closing anyway")
+	}
+
+	atomic.StoreInt32(&c.state, stateClosed)
+	c.release()
+	return nil
+}
+
+// handlePush处理收到的数据分片：按序交付、缓存乱序分片，并回复SAck
+func (c *Conn) handlePush(seg segment) {
+	c.rcvMu.Lock()
+	delivered := false
+	switch {
+	case seg.seq < c.expectSeq:
+		log.Println("[D] receive delay seg")
+
+	case seg.seq == c.expectSeq:
+		delivered = c.reassemble(seg)
+		c.expectSeq++
+		for {
+			s, ok := c.ooBuf[c.expectSeq]
+			if !ok {
+				break
+			}
+			if c.reassemble(s) {
+				delivered = true
+			}
+			delete(c.ooBuf, c.expectSeq)
+			c.expectSeq++
+		}
+
+	default: // seg.seq > c.expectSeq，乱序到达，缓存等待空洞补齐
+		if _, ok := c.ooBuf[seg.seq]; !ok {
+			c.ooBuf[seg.seq] = seg
+		}
+	}
+
+	base := c.expectSeq
+	var bitmap uint32
+	for i := uint32(0); i < sackBits; i++ {
+		if _, ok := c.ooBuf[base+1+i]; ok {
+			bitmap |= 1 << i
+		}
+	}
+	c.rcvMu.Unlock()
+
+	c.tx(segment{cmd: cmdSAck, seq: base, sackBitmap: bitmap})
+
+	// 只在确实有完整消息投递到rcvbuf时才唤醒阻塞的peek；乱序缓存、重复/延迟包、
+	// 尚未凑齐的分片都不是"可交付"事件，不应唤醒等待方去空检查rcvbuf。
+	if delivered {
+		select {
+		case c.recvPush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// handleSAck根据累计确认点base与选择性确认位图，清理发送队列并推进窗口
+func (c *Conn) handleSAck(base uint32, bitmap uint32) {
+	c.sendMu.Lock()
+
+	for ele := c.sendQ.Front(); ele != nil; {
+		os := ele.Value.(*outSeg)
+		if os.seg.seq >= base {
+			break
+		}
+		next := ele.Next()
+		c.sample(os)
+		c.sendQ.Remove(ele)
+		delete(c.sendIndex, os.seg.seq)
+		ele = next
+	}
+
+	// 选择性确认：位图命中的、空洞之后已到达的分片，从队列摘除但不影响重传计时的队首判断
+	for i := uint32(0); i < sackBits; i++ {
+		if bitmap&(1<<i) == 0 {
+			continue
+		}
+		seq := base + 1 + i
+		if ele, ok := c.sendIndex[seq]; ok {
+			os := ele.Value.(*outSeg)
+			c.sample(os)
+			c.sendQ.Remove(ele)
+			delete(c.sendIndex, seq)
+		}
+	}
+
+	if c.sendQ.Len() > 0 {
+		c.timer.Reset(c.currentTimeout())
+	} else {
+		c.timer.Stop()
+	}
+	c.sendMu.Unlock()
+
+	// 腾出窗口空间，唤醒阻塞在enqueue里的Write
+	select {
+	case c.writable <- true:
+	default:
+	}
+}
+
+// sample对非重传分片采样rtt，并据此更新RTO估计（Karn算法：重传过的分片不采样）
+func (c *Conn) sample(os *outSeg) {
+	if os.retransmitted {
+		return
+	}
+	r := time.Now().Sub(os.sentAt)
+	c.rtt = r.Microseconds()
+	if c.rtt < c.minrtt {
+		c.minrtt = c.rtt
+	}
+	if c.rtt > c.maxrtt {
+		c.maxrtt = c.rtt
+	}
+	log.Printf("[D] rtt %d minrtt: %d maxrtt: %d\n", c.rtt, c.minrtt, c.maxrtt)
+
+	c.updateRTO(r)
+}
+
+// updateRTO按照Jacobson/Karn算法，用一次新鲜的RTT采样r更新srtt/rttvar，
+// 并据此推出rto = srtt + 4*rttvar，取值范围[_MIN_RTO, _MAX_RTO]。
+// 更新后清除当前的指数回退状态。
+func (c *Conn) updateRTO(r time.Duration) {
+	c.rtoMu.Lock()
+	if c.srtt == 0 {
+		c.srtt = r
+		c.rttvar = r / 2
+	} else {
+		delta := c.srtt - r
+		if delta < 0 {
+			delta = -delta
+		}
+		c.rttvar = (c.rttvar*3 + delta) / 4 // rttvar = (1-β)·rttvar + β·|srtt-r|, β=1/4
+		c.srtt = (c.srtt*7 + r) / 8         // srtt = (1-α)·srtt + α·r, α=1/8
+	}
+
+	rto := c.srtt + 4*c.rttvar
+	if rto < _MIN_RTO {
+		rto = _MIN_RTO
+	}
+	if rto > _MAX_RTO {
+		rto = _MAX_RTO
+	}
+	c.rto = rto
+	c.backoffRto = 0
+	c.rtoMu.Unlock()
+}
+
+// currentTimeout返回队首分片下一次应使用的超时时长：
+// 处于指数回退状态时使用回退值，否则使用RTO估计值。
+func (c *Conn) currentTimeout() time.Duration {
+	c.rtoMu.Lock()
+	defer c.rtoMu.Unlock()
+	if c.backoffRto > 0 {
+		return c.backoffRto
+	}
+	return c.rto
+}
+
+// backoff把当前超时时长翻倍（封顶_MAX_RTO），在收到新鲜ack前持续生效
+func (c *Conn) backoff() time.Duration {
+	c.rtoMu.Lock()
+	defer c.rtoMu.Unlock()
+	base := c.rto
+	if c.backoffRto > 0 {
+		base = c.backoffRto
+	}
+	next := base * 2
+	if next > _MAX_RTO {
+		next = _MAX_RTO
+	}
+	c.backoffRto = next
+	return next
+}
+
+// RTO返回当前的重传超时估计值
+func (c *Conn) RTO() time.Duration {
+	c.rtoMu.Lock()
+	defer c.rtoMu.Unlock()
+	return c.rto
+}
+
+// SRTT返回当前的平滑RTT估计值
+func (c *Conn) SRTT() time.Duration {
+	c.rtoMu.Lock()
+	defer c.rtoMu.Unlock()
+	return c.srtt
+}
+
+func (c *Conn) tx(seg segment) {
+	if _, err := c.write(encodeSegment(seg)); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// internalRecvLoop是Dial得到的Conn独占读取自己socket的协程；
+// Listener一侧的Conn由Listener的读循环demux后调用input，不运行这个协程。
+func (c *Conn) internalRecvLoop(conn *net.UDPConn) {
+	buf := make([]byte, maxPktSize)
+	for {
+		nr, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		seg, err := decodeSegment(buf, nr, c.raddr)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		c.input(seg)
+	}
+}
+
+var errTimeout = errors.New("i/o timeout")
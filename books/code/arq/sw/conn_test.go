@@ -0,0 +1,77 @@
+package sw
+
+import (
+	"testing"
+	"time"
+)
+
+// TestUpdateRTOJacobsonKarn验证updateRTO按Jacobson/Karn公式推出srtt/rttvar/rto，
+// 首次采样与后续采样分别走初始化分支与递推分支，且重传过的分片不参与采样（Karn算法）。
+func TestUpdateRTOJacobsonKarn(t *testing.T) {
+	c := &Conn{}
+
+	c.updateRTO(100 * time.Millisecond)
+	if got, want := c.SRTT(), 100*time.Millisecond; got != want {
+		t.Fatalf("SRTT after first sample = %v, want %v", got, want)
+	}
+	// 首次采样：rttvar = r/2 = 50ms，rto = srtt + 4*rttvar = 100ms + 200ms = 300ms
+	if got, want := c.RTO(), 300*time.Millisecond; got != want {
+		t.Fatalf("RTO after first sample = %v, want %v", got, want)
+	}
+
+	c.updateRTO(150 * time.Millisecond)
+	// 第二次采样：delta=|100-150|=50ms，rttvar=(50*3+50)/4=50ms，srtt=(100*7+150)/8≈106.25ms
+	wantSrtt := (100*time.Millisecond*7 + 150*time.Millisecond) / 8
+	if got := c.SRTT(); got != wantSrtt {
+		t.Fatalf("SRTT after second sample = %v, want %v", got, wantSrtt)
+	}
+
+	os := &outSeg{sentAt: time.Now(), retransmitted: true}
+	before := c.SRTT()
+	c.sample(os)
+	if got := c.SRTT(); got != before {
+		t.Fatalf("sample() updated SRTT for a retransmitted segment (Karn violation): got %v, want unchanged %v", got, before)
+	}
+}
+
+// TestRTOClampedToMinMax验证rto估计值被钳制在[_MIN_RTO, _MAX_RTO]之间，
+// 避免极小的RTT样本导致重传过于激进、或极大的RTT样本导致重传迟迟不触发。
+func TestRTOClampedToMinMax(t *testing.T) {
+	lo := &Conn{}
+	lo.updateRTO(1 * time.Microsecond)
+	if got := lo.RTO(); got != _MIN_RTO {
+		t.Fatalf("RTO with tiny sample = %v, want clamped to _MIN_RTO %v", got, _MIN_RTO)
+	}
+
+	hi := &Conn{}
+	hi.updateRTO(100 * time.Second)
+	if got := hi.RTO(); got != _MAX_RTO {
+		t.Fatalf("RTO with huge sample = %v, want clamped to _MAX_RTO %v", got, _MAX_RTO)
+	}
+}
+
+// TestBackoffResetsOnFreshAck验证超时重传按指数回退翻倍backoffRto，
+// 而一次非重传分片的新鲜ack（updateRTO）会清除回退状态。
+func TestBackoffResetsOnFreshAck(t *testing.T) {
+	c := &Conn{}
+	c.updateRTO(10 * time.Millisecond) // rto = 10ms + 4*5ms = 30ms，恰好等于_MIN_RTO
+	base := c.RTO()
+
+	first := c.backoff()
+	if want := base * 2; first != want {
+		t.Fatalf("first backoff = %v, want %v", first, want)
+	}
+	if c.backoffRto != first {
+		t.Fatalf("backoffRto = %v, want %v", c.backoffRto, first)
+	}
+
+	second := c.backoff()
+	if want := first * 2; second != want {
+		t.Fatalf("second backoff = %v, want %v", second, want)
+	}
+
+	c.updateRTO(12 * time.Millisecond)
+	if c.backoffRto != 0 {
+		t.Fatalf("backoffRto after fresh ack = %v, want 0", c.backoffRto)
+	}
+}
@@ -0,0 +1,206 @@
+package sw
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// dialTimeout是Dial发出SYN后等待SYNACK的上限
+const dialTimeout = 5 * time.Second
+
+// acceptBacklog是Accept队列的最大积压数，超出后新连接的SYN会被丢弃，由对端重试
+const acceptBacklog = 64
+
+// Listener在一个UDP socket上同时服务多个对端，按来源地址把收到的报文
+// 分发（demux）给各自的*Conn。用法类似net.TCPListener：Accept阻塞直到
+// 有新连接完成握手。
+type Listener struct {
+	conn *net.UDPConn
+
+	mu    sync.Mutex
+	peers map[string]*Conn
+
+	acceptCh chan *Conn
+
+	// closedCh在Close时关闭，readLoop和Accept都select它来感知关闭；
+	// acceptCh本身永远不关闭，避免readLoop对它的发送与Close的close竞争
+	// 触发"send on closed channel" panic。
+	closedCh  chan struct{}
+	closeOnce sync.Once
+}
+
+// Listen在addr上监听，返回一个可以Accept多个对端连接的Listener
+func Listen(network, addr string) (*Listener, error) {
+	laddr, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Listener{
+		conn:     conn,
+		peers:    make(map[string]*Conn),
+		acceptCh: make(chan *Conn, acceptBacklog),
+		closedCh: make(chan struct{}),
+	}
+	go l.readLoop()
+	return l, nil
+}
+
+// Accept阻塞等待下一个完成握手的对端连接
+func (l *Listener) Accept() (*Conn, error) {
+	select {
+	case c := <-l.acceptCh:
+		return c, nil
+	case <-l.closedCh:
+		return nil, fmt.Errorf("sw: listener closed")
+	}
+}
+
+// Addr返回Listener监听的本地地址
+func (l *Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// Close关闭底层socket，并释放所有已通过Accept派发出去的Conn：否则它们的
+// internalSendLoop会在socket关闭后继续对队首分片做超时重传，永久泄漏协程
+// 和计时器，且上层对已失效Conn的Write还会因为write()报错被tx()吞掉而返回
+// 成功假象。
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closedCh)
+	})
+
+	l.mu.Lock()
+	peers := make([]*Conn, 0, len(l.peers))
+	for _, c := range l.peers {
+		peers = append(peers, c)
+	}
+	l.mu.Unlock()
+
+	for _, c := range peers {
+		c.handlePeerClose()
+	}
+
+	return l.conn.Close()
+}
+
+// readLoop是Listener唯一的读协程：读取数据报、按来源地址demux给已存在的
+// Conn，或者在收到cmdSYN时建立一个新的Conn并交给Accept。
+func (l *Listener) readLoop() {
+	buf := make([]byte, maxPktSize)
+	for {
+		nr, raddr, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		seg, err := decodeSegment(buf, nr, raddr)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		key := raddr.String()
+		l.mu.Lock()
+		c, ok := l.peers[key]
+		if !ok {
+			if seg.cmd != cmdSYN {
+				l.mu.Unlock()
+				continue
+			}
+
+			// 这个握手没有第三次挥手确认SYNACK：对端一收到SYNACK就认为连接已
+			// 建立，不会再重发SYN。所以必须先确认acceptCh还有空位，才能注册
+			// peer、回SYNACK；否则一旦accept队列满了就会丢出一个对端以为已经
+			// 建立、而服务端应用永远Accept不到的半开连接，且原先挂的Conn和它
+			// 的internalSendLoop协程也随之泄漏。队列满就在创建Conn之前丢弃，
+			// 对端的SYN会因为收不到SYNACK而超时重试。
+			if len(l.acceptCh) >= cap(l.acceptCh) {
+				l.mu.Unlock()
+				continue
+			}
+
+			c = newConn(l.conn.LocalAddr(), raddr, func(b []byte) (int, error) {
+				return l.conn.WriteTo(b, raddr)
+			}, func() error {
+				l.mu.Lock()
+				delete(l.peers, key)
+				l.mu.Unlock()
+				return nil
+			})
+			l.peers[key] = c
+			l.mu.Unlock()
+
+			atomic.StoreInt32(&c.state, stateEstablished)
+			c.tx(segment{cmd: cmdSYNACK})
+			// acceptCh永远不会被关闭（见closedCh注释），这里只需在Close
+			// 并发发生时避免投递到一个没有人再读取的队列
+			select {
+			case l.acceptCh <- c:
+			case <-l.closedCh:
+			}
+			continue
+		}
+		l.mu.Unlock()
+
+		c.input(seg)
+	}
+}
+
+// Dial向addr发起连接，完成SYN/SYNACK握手后返回一个可用的*Conn
+func Dial(network, addr string) (*Conn, error) {
+	raddr, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialUDP(network, nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newConn(conn.LocalAddr(), raddr, conn.Write, conn.Close)
+	go c.internalRecvLoop(conn)
+
+	// SYN和SYNACK都可能在链路上被丢弃，握手阶段不能像已建立连接那样等
+	// internalSendLoop的超时计时器来驱动重传，所以这里自己按指数回退重发SYN，直到握手成功
+	// 或总时长超过dialTimeout——单个丢包不应该让Dial直接判定失败。
+	deadline := time.Now().Add(dialTimeout)
+	rto := defaultRto
+	c.tx(segment{cmd: cmdSYN})
+	timer := time.NewTimer(rto)
+	defer timer.Stop()
+	for {
+		select {
+		case <-c.synAckCh:
+			return c, nil
+		case <-timer.C:
+			if time.Now().After(deadline) {
+				// release关闭closedCh并停止timer，internalSendLoop据此退出；
+				// 只调用conn.Close()会让internalSendLoop永远阻塞在select上
+				// （发送队列空，timer.C永不触发），协程和它的time.Timer就此泄漏。
+				c.release()
+				return nil, fmt.Errorf("sw: dial %s: handshake timeout", addr)
+			}
+			c.tx(segment{cmd: cmdSYN})
+			rto *= 2
+			if rto > _MAX_RTO {
+				rto = _MAX_RTO
+			}
+			wait := rto
+			if left := time.Until(deadline); left < wait {
+				wait = left
+			}
+			timer.Reset(wait)
+		}
+	}
+}
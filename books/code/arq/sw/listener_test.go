@@ -0,0 +1,124 @@
+package sw
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestListenerCloseReleasesAcceptedConns验证Listener.Close会释放所有已通过
+// Accept派发出去的Conn：closedCh被关闭（internalSendLoop据此退出，不再泄漏
+// 协程和计时器），状态转为stateClosed，后续Write也会报错而不是静默丢数据。
+func TestListenerCloseReleasesAcceptedConns(t *testing.T) {
+	ln, err := Listen("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	acceptErrCh := make(chan error, 1)
+	serverCh := make(chan *Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			acceptErrCh <- err
+			return
+		}
+		serverCh <- c
+	}()
+
+	client, err := Dial("udp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+
+	var server *Conn
+	select {
+	case server = <-serverCh:
+	case err := <-acceptErrCh:
+		t.Fatalf("Accept: %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Accept timed out")
+	}
+
+	if err := ln.Close(); err != nil {
+		t.Fatalf("Listener Close: %v", err)
+	}
+
+	select {
+	case <-server.closedCh:
+	case <-time.After(time.Second):
+		t.Fatal("accepted Conn was not released by Listener.Close")
+	}
+
+	if got := atomic.LoadInt32(&server.state); got != stateClosed {
+		t.Fatalf("accepted Conn state = %d, want stateClosed", got)
+	}
+
+	if _, err := server.Write([]byte("x")); err == nil {
+		t.Fatal("Write on a Conn released by Listener.Close should fail, got nil error")
+	}
+}
+
+// TestSACKRetransmissionAfterLoss把两个Conn通过自定义的write函数直接对接
+// （不经过真实UDP socket，Listener/Dial正是这样注入write的），模拟第一个数据
+// 分片的首次发送在链路上被丢弃，驱动sender的internalSendLoop基于RTO超时完成
+// 选择性重传，最终验证接收方仍能按序收全所有消息。
+func TestSACKRetransmissionAfterLoss(t *testing.T) {
+	var sender, receiver *Conn
+
+	var mu sync.Mutex
+	dropFirstPush := true
+
+	sender = newConn(nil, nil, func(b []byte) (int, error) {
+		seg, err := decodeSegment(b, len(b), nil)
+		if err != nil {
+			return 0, err
+		}
+		mu.Lock()
+		drop := dropFirstPush && seg.cmd == cmdPush && seg.seq == 0
+		if drop {
+			dropFirstPush = false
+		}
+		mu.Unlock()
+		if drop {
+			return len(b), nil // 模拟丢包：报文在网络上丢失，但本地write调用本身不报错
+		}
+		receiver.input(seg)
+		return len(b), nil
+	}, func() error { return nil })
+	defer sender.release()
+
+	receiver = newConn(nil, nil, func(b []byte) (int, error) {
+		seg, err := decodeSegment(b, len(b), nil)
+		if err != nil {
+			return 0, err
+		}
+		sender.input(seg)
+		return len(b), nil
+	}, func() error { return nil })
+	defer receiver.release()
+
+	atomic.StoreInt32(&sender.state, stateEstablished)
+	atomic.StoreInt32(&receiver.state, stateEstablished)
+
+	want := [][]byte{[]byte("aaa"), []byte("bbb"), []byte("ccc")}
+	for i, msg := range want {
+		if _, err := sender.Write(msg); err != nil {
+			t.Fatalf("Write #%d: %v", i, err)
+		}
+	}
+
+	receiver.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for i, msg := range want {
+		got, err := receiver.Recv()
+		if err != nil {
+			t.Fatalf("Recv #%d: %v", i, err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Fatalf("Recv #%d mismatch: got %q, want %q", i, got, msg)
+		}
+	}
+}
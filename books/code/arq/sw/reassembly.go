@@ -0,0 +1,105 @@
+package sw
+
+import (
+	"log"
+	"time"
+)
+
+// defaultReassemblyTimeout是消息重组缓冲区中，一条不完整消息允许存活的默认时长
+const defaultReassemblyTimeout = 30 * time.Second
+
+// reassemblyCap是重组缓冲区能同时容纳的、尚未凑齐的消息数上限
+const reassemblyCap = 64
+
+// fragBuf保存某个消息id已经收到的分片，等待凑齐fragCnt个分片后整体交付
+type fragBuf struct {
+	msgID    uint32
+	fragCnt  uint16
+	frags    map[uint16][]byte
+	lastSeen time.Time
+}
+
+// SetReassemblyTimeout配置消息重组的超时时长：一条消息的分片在此时长内仍未凑齐，
+// 会被视为无法重组而丢弃。调用前必须先建立连接。
+func (c *Conn) SetReassemblyTimeout(d time.Duration) {
+	c.rcvMu.Lock()
+	c.reassemblyTimeout = d
+	c.rcvMu.Unlock()
+}
+
+// reassemble把一个已经按序到达、CRC校验通过的分片计入其所属消息的重组缓冲区；
+// 调用方必须持有rcvMu。消息的全部分片凑齐后，拼接为一条完整消息追加到rcvbuf，
+// 并返回true；分片尚不完整时不触碰rcvbuf，返回false。调用方应只在返回true时
+// 唤醒阻塞的读者——半成品分片不是"有数据可读"的信号。
+func (c *Conn) reassemble(seg segment) bool {
+	c.evictExpiredReassemblyLocked()
+
+	if seg.fragCnt <= 1 {
+		c.rcvbuf.PushBack(segment{data: seg.data})
+		return true
+	}
+
+	ele, ok := c.reassembleIndex[seg.msgID]
+	var fb *fragBuf
+	if ok {
+		fb = ele.Value.(*fragBuf)
+		c.reassembleLRU.MoveToBack(ele)
+	} else {
+		fb = &fragBuf{msgID: seg.msgID, fragCnt: seg.fragCnt, frags: make(map[uint16][]byte, seg.fragCnt)}
+		ele = c.reassembleLRU.PushBack(fb)
+		c.reassembleIndex[seg.msgID] = ele
+		c.evictOverflowReassemblyLocked()
+	}
+	fb.frags[seg.fragIdx] = seg.data
+	fb.lastSeen = time.Now()
+
+	if uint16(len(fb.frags)) < fb.fragCnt {
+		return false
+	}
+
+	data := make([]byte, 0, len(fb.frags)*len(seg.data))
+	for i := uint16(0); i < fb.fragCnt; i++ {
+		data = append(data, fb.frags[i]...)
+	}
+	c.rcvbuf.PushBack(segment{data: data})
+
+	c.reassembleLRU.Remove(ele)
+	delete(c.reassembleIndex, seg.msgID)
+	return true
+}
+
+// evictExpiredReassemblyLocked丢弃超过reassemblyTimeout仍未凑齐分片的消息
+func (c *Conn) evictExpiredReassemblyLocked() {
+	timeout := c.reassemblyTimeout
+	if timeout <= 0 {
+		timeout = defaultReassemblyTimeout
+	}
+
+	for {
+		ele := c.reassembleLRU.Front()
+		if ele == nil {
+			return
+		}
+		fb := ele.Value.(*fragBuf)
+		if time.Since(fb.lastSeen) < timeout {
+			return
+		}
+		log.Printf("[W] reassembly timeout, drop msg %d (%d/%d frags)\n",
+			fb.msgID, len(fb.frags), fb.fragCnt)
+		c.reassembleLRU.Remove(ele)
+		delete(c.reassembleIndex, fb.msgID)
+	}
+}
+
+// evictOverflowReassemblyLocked在重组缓冲区超过reassemblyCap时，
+// 淘汰最久未被触达的消息，使其成为有界LRU
+func (c *Conn) evictOverflowReassemblyLocked() {
+	for c.reassembleLRU.Len() > reassemblyCap {
+		ele := c.reassembleLRU.Front()
+		fb := ele.Value.(*fragBuf)
+		log.Printf("[W] reassembly buffer full, evict msg %d (%d/%d frags)\n",
+			fb.msgID, len(fb.frags), fb.fragCnt)
+		c.reassembleLRU.Remove(ele)
+		delete(c.reassembleIndex, fb.msgID)
+	}
+}
@@ -0,0 +1,61 @@
+package sw
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// TestLoopbackFragmentReassembly通过真实的UDP loopback连接发送一条超过mss的消息，
+// 验证Write端的自动分片与Read端的重组（含CRC32校验）拼回同一条完整消息。
+func TestLoopbackFragmentReassembly(t *testing.T) {
+	ln, err := Listen("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+
+	acceptErrCh := make(chan error, 1)
+	serverCh := make(chan *Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			acceptErrCh <- err
+			return
+		}
+		serverCh <- c
+	}()
+
+	client, err := Dial("udp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+
+	var server *Conn
+	select {
+	case server = <-serverCh:
+	case err := <-acceptErrCh:
+		t.Fatalf("Accept: %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Accept timed out")
+	}
+	defer server.Close()
+
+	want := make([]byte, 3*1400+137) // 跨越好几个mss，确保触发多分片重组
+	rand.New(rand.NewSource(1)).Read(want)
+
+	if _, err := client.Write(want); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	got, err := server.Recv()
+	if err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("reassembled message mismatch: got %d bytes, want %d bytes", len(got), len(want))
+	}
+}
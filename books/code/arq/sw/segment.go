@@ -0,0 +1,143 @@
+package sw
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"time"
+)
+
+const (
+	_ = iota
+	cmdPush
+	cmdAck
+	cmdSAck
+	cmdSYN
+	cmdSYNACK
+	cmdFIN
+	cmdRST
+)
+
+// sackBits是SAck位图能够描述的、expectSeq之后的分片数量
+const sackBits = 32
+
+var (
+	cmdSize     = 1
+	seqSize     = 4
+	sackBmpSize = 4
+
+	// msgIDSize/fragIdxSize/fragCntSize构成cmdPush报文在seq之后的附加头部，
+	// crcSize是追加在数据之后的CRC32C校验和
+	msgIDSize   = 4
+	fragIdxSize = 2
+	fragCntSize = 2
+	crcSize     = 4
+
+	overHead     = cmdSize + seqSize + msgIDSize + fragIdxSize + fragCntSize
+	pushOverHead = overHead + crcSize
+	sackOverHead = cmdSize + seqSize + sackBmpSize
+
+	defaultRto = time.Millisecond * 100
+
+	// ErrBadCRC表示收到的分片CRC32校验失败，该分片会被当作丢包直接抛弃
+	ErrBadCRC = fmt.Errorf("sw: bad crc32 checksum")
+)
+
+// crcTable是cmdPush报文尾部CRC32C校验和使用的多项式表
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// 默认发送窗口大小，即允许同时在途的未确认分片数
+const defaultSwnd = 32
+
+type segment struct {
+	cmd  uint8
+	seq  uint32
+	data []byte
+
+	raddr *net.UDPAddr
+
+	// sackBitmap仅在cmd为cmdSAck时有效，bit i表示seq(base+1+i)是否已收到
+	sackBitmap uint32
+
+	// msgID/fragIdx/fragCnt仅在cmd为cmdPush时有效，用于在接收端按消息重组分片
+	msgID   uint32
+	fragIdx uint16
+	fragCnt uint16
+}
+
+// encode把segment编码为可发送的报文；cmdSYN/cmdSYNACK/cmdFIN/cmdRST等控制包
+// 不携带数据，复用cmdAck的单字节格式。cmdPush报文附带消息重组信息，
+// 并在末尾追加对(头部||数据)计算的CRC32C校验和。
+func encodeSegment(buf segment) []byte {
+	switch buf.cmd {
+	case cmdSAck:
+		data := make([]byte, sackOverHead)
+		data[0] = buf.cmd
+		binary.BigEndian.PutUint32(data[cmdSize:], buf.seq)
+		binary.BigEndian.PutUint32(data[cmdSize+seqSize:], buf.sackBitmap)
+		return data
+	case cmdAck, cmdSYN, cmdSYNACK, cmdFIN, cmdRST:
+		return []byte{buf.cmd}
+	default:
+		data := make([]byte, overHead, pushOverHead+len(buf.data))
+		data[0] = buf.cmd
+		binary.BigEndian.PutUint32(data[cmdSize:], buf.seq)
+		binary.BigEndian.PutUint32(data[cmdSize+seqSize:], buf.msgID)
+		binary.BigEndian.PutUint16(data[cmdSize+seqSize+msgIDSize:], buf.fragIdx)
+		binary.BigEndian.PutUint16(data[cmdSize+seqSize+msgIDSize+fragIdxSize:], buf.fragCnt)
+		data = append(data, buf.data...)
+
+		crc := crc32.Checksum(data, crcTable)
+		crcBuf := make([]byte, crcSize)
+		binary.BigEndian.PutUint32(crcBuf, crc)
+		return append(data, crcBuf...)
+	}
+}
+
+// decodeSegment解析一个收到的报文，raddr是该报文的来源地址。
+// cmdPush报文的CRC32C校验和不匹配时返回ErrBadCRC，调用方应将其当作丢包处理。
+func decodeSegment(buf []byte, nr int, raddr *net.UDPAddr) (segment, error) {
+	if nr == 1 {
+		switch buf[0] {
+		case cmdAck, cmdSYN, cmdSYNACK, cmdFIN, cmdRST:
+			return segment{cmd: buf[0], raddr: raddr}, nil
+		}
+	}
+
+	if nr == sackOverHead && buf[0] == cmdSAck {
+		seg := segment{
+			cmd:        cmdSAck,
+			seq:        binary.BigEndian.Uint32(buf[cmdSize:]),
+			sackBitmap: binary.BigEndian.Uint32(buf[cmdSize+seqSize:]),
+			raddr:      raddr,
+		}
+		return seg, nil
+	}
+
+	if nr < pushOverHead {
+		return segment{}, fmt.Errorf("invalid overhead")
+	}
+
+	payloadEnd := nr - crcSize
+	gotCrc := binary.BigEndian.Uint32(buf[payloadEnd:nr])
+	wantCrc := crc32.Checksum(buf[:payloadEnd], crcTable)
+	if gotCrc != wantCrc {
+		return segment{}, ErrBadCRC
+	}
+
+	seq := binary.BigEndian.Uint32(buf[cmdSize:])
+	msgID := binary.BigEndian.Uint32(buf[cmdSize+seqSize:])
+	fragIdx := binary.BigEndian.Uint16(buf[cmdSize+seqSize+msgIDSize:])
+	fragCnt := binary.BigEndian.Uint16(buf[cmdSize+seqSize+msgIDSize+fragIdxSize:])
+	seg := segment{
+		cmd:     buf[0],
+		seq:     seq,
+		msgID:   msgID,
+		fragIdx: fragIdx,
+		fragCnt: fragCnt,
+		data:    append([]byte(nil), buf[overHead:payloadEnd]...),
+		raddr:   raddr,
+	}
+	return seg, nil
+}
@@ -0,0 +1,94 @@
+package sw
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// TestEncodeDecodeSegmentRoundTrip覆盖三类报文的编解码往返：cmdPush（含重组信息与CRC）、
+// cmdSAck（含位图）、以及不带数据的控制报文（cmdSYN等复用cmdAck的单字节格式）
+func TestEncodeDecodeSegmentRoundTrip(t *testing.T) {
+	raddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+
+	cases := []struct {
+		name string
+		in   segment
+	}{
+		{
+			name: "push",
+			in: segment{
+				cmd:     cmdPush,
+				seq:     7,
+				msgID:   42,
+				fragIdx: 1,
+				fragCnt: 3,
+				data:    []byte("hello sliding window"),
+			},
+		},
+		{
+			name: "push empty payload",
+			in: segment{
+				cmd:     cmdPush,
+				seq:     0,
+				msgID:   1,
+				fragIdx: 0,
+				fragCnt: 1,
+				data:    []byte{},
+			},
+		},
+		{
+			name: "sack",
+			in: segment{
+				cmd:        cmdSAck,
+				seq:        100,
+				sackBitmap: 0x0000FFFF,
+			},
+		},
+		{name: "syn", in: segment{cmd: cmdSYN}},
+		{name: "synack", in: segment{cmd: cmdSYNACK}},
+		{name: "fin", in: segment{cmd: cmdFIN}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := encodeSegment(tc.in)
+			got, err := decodeSegment(buf, len(buf), raddr)
+			if err != nil {
+				t.Fatalf("decodeSegment: %v", err)
+			}
+
+			if got.cmd != tc.in.cmd || got.seq != tc.in.seq || got.sackBitmap != tc.in.sackBitmap ||
+				got.msgID != tc.in.msgID || got.fragIdx != tc.in.fragIdx || got.fragCnt != tc.in.fragCnt {
+				t.Fatalf("round-trip mismatch: got %+v, want %+v", got, tc.in)
+			}
+			if tc.in.cmd == cmdPush && !bytes.Equal(got.data, tc.in.data) {
+				t.Fatalf("round-trip data mismatch: got %q, want %q", got.data, tc.in.data)
+			}
+			if got.raddr != raddr {
+				t.Fatalf("raddr not preserved: got %v, want %v", got.raddr, raddr)
+			}
+		})
+	}
+}
+
+// TestDecodeSegmentBadCRC验证cmdPush报文被篡改后CRC32校验不通过时返回ErrBadCRC，
+// 而不是把损坏的数据当作合法分片交付
+func TestDecodeSegmentBadCRC(t *testing.T) {
+	raddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+
+	buf := encodeSegment(segment{
+		cmd:     cmdPush,
+		seq:     1,
+		msgID:   1,
+		fragIdx: 0,
+		fragCnt: 1,
+		data:    []byte("payload"),
+	})
+	buf[len(buf)-1] ^= 0xFF // 翻转CRC尾部的一个字节，模拟传输损坏
+
+	_, err := decodeSegment(buf, len(buf), raddr)
+	if err != ErrBadCRC {
+		t.Fatalf("decodeSegment: got err %v, want ErrBadCRC", err)
+	}
+}